@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/credentials/ec2rolecreds"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	log "github.com/sirupsen/logrus"
+)
+
+// initS3Client builds an S3 client for the side named by prefix ("SRC" or
+// "DST"), selecting a credential provider based on <prefix>_AUTH_MODE:
+//
+//   - static (default): <prefix>_ACCESS_KEY / <prefix>_SECRET_KEY, for
+//     back-compat with existing cross-account key pairs.
+//   - profile: the named profile from the shared AWS config/credentials
+//     files, via <prefix>_PROFILE.
+//   - iam: EC2 instance metadata credentials, refreshed automatically. Use
+//     this when the binary runs on an EC2 host with an attached IAM role.
+//   - assume-role: STS AssumeRole using <prefix>_ROLE_ARN (required),
+//     <prefix>_EXTERNAL_ID and <prefix>_SESSION_NAME (both optional).
+func initS3Client(prefix string) *s3.Client {
+	authMode := os.Getenv(prefix + "_AUTH_MODE")
+	if authMode == "" {
+		authMode = "static"
+	}
+
+	opts := []func(*config.LoadOptions) error{
+		config.WithRegion(os.Getenv(prefix + "_REGION")),
+	}
+	if endpointOpt := endpointLoadOption(prefix); endpointOpt != nil {
+		opts = append(opts, endpointOpt)
+	}
+
+	switch authMode {
+	case "static":
+		opts = append(opts, config.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(os.Getenv(prefix+"_ACCESS_KEY"), os.Getenv(prefix+"_SECRET_KEY"), ""),
+		))
+	case "profile":
+		opts = append(opts, config.WithSharedConfigProfile(os.Getenv(prefix+"_PROFILE")))
+	case "iam", "assume-role":
+		// Credentials for these modes are attached below, once the base
+		// config has loaded (assume-role builds its STS client from it).
+	default:
+		log.Fatalf("Unknown %s_AUTH_MODE %q (expected static, profile, iam, or assume-role)", prefix, authMode)
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.TODO(), opts...)
+	if err != nil {
+		log.Fatalf("Unable to load AWS config: %v", err)
+	}
+
+	switch authMode {
+	case "iam":
+		cfg.Credentials = aws.NewCredentialsCache(ec2rolecreds.New(func(o *ec2rolecreds.Options) {
+			o.Client = imds.New(imds.Options{})
+		}))
+	case "assume-role":
+		roleARN := os.Getenv(prefix + "_ROLE_ARN")
+		if roleARN == "" {
+			log.Fatalf("%s_AUTH_MODE=assume-role requires %s_ROLE_ARN", prefix, prefix)
+		}
+		stsClient := sts.NewFromConfig(cfg)
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(stsClient, roleARN, func(o *stscreds.AssumeRoleOptions) {
+			if externalID := os.Getenv(prefix + "_EXTERNAL_ID"); externalID != "" {
+				o.ExternalID = aws.String(externalID)
+			}
+			if sessionName := os.Getenv(prefix + "_SESSION_NAME"); sessionName != "" {
+				o.RoleSessionName = sessionName
+			}
+		}))
+	}
+
+	return s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if usePathStyle(prefix) {
+			o.UsePathStyle = true
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// destStorageClass returns the DST_STORAGE_CLASS to write destination
+// objects with, defaulting to STANDARD and validated against the SDK's set
+// of known storage classes so a typo fails fast instead of surfacing as an
+// opaque API error mid-transfer.
+func destStorageClass() types.StorageClass {
+	v := os.Getenv("DST_STORAGE_CLASS")
+	if v == "" {
+		return types.StorageClassStandard
+	}
+	sc := types.StorageClass(v)
+	for _, valid := range sc.Values() {
+		if sc == valid {
+			return sc
+		}
+	}
+	log.Fatalf("Unknown DST_STORAGE_CLASS %q", v)
+	return sc
+}
+
+// destSSE returns the DST_SSE server-side encryption mode ("AES256" or
+// "aws:kms"), or "" to leave encryption up to the destination bucket's
+// default settings.
+func destSSE() types.ServerSideEncryption {
+	v := os.Getenv("DST_SSE")
+	if v == "" {
+		return ""
+	}
+	sse := types.ServerSideEncryption(v)
+	switch sse {
+	case types.ServerSideEncryptionAes256, types.ServerSideEncryptionAwsKms:
+		return sse
+	default:
+		log.Fatalf("Unknown DST_SSE %q (expected AES256 or aws:kms)", v)
+		return ""
+	}
+}
+
+// destSSEKMSKeyID returns DST_SSE_KMS_KEY_ID when DST_SSE is set to
+// aws:kms, or nil otherwise.
+func destSSEKMSKeyID() *string {
+	if destSSE() != types.ServerSideEncryptionAwsKms {
+		return nil
+	}
+	if keyID := os.Getenv("DST_SSE_KMS_KEY_ID"); keyID != "" {
+		return aws.String(keyID)
+	}
+	return nil
+}
+
+// destACL returns the DST_ACL canned ACL to apply to destination objects,
+// or "" to leave the bucket's default ACL/policy in effect.
+func destACL() types.ObjectCannedACL {
+	return types.ObjectCannedACL(os.Getenv("DST_ACL"))
+}
+
+// copyObjectTags mirrors key's tag set from the source bucket onto the
+// destination object, since neither CopyObject with MetadataDirective=COPY
+// nor a streaming PutObject carries tags across on its own.
+func copyObjectTags(ctx context.Context, src, dst *s3.Client, srcBucket, dstBucket, key string) error {
+	recordS3Call("GetObjectTagging")
+	tagsOut, err := src.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+		Bucket: aws.String(srcBucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return fmt.Errorf("get object tagging error: %w", err)
+	}
+	if len(tagsOut.TagSet) == 0 {
+		return nil
+	}
+
+	recordS3Call("PutObjectTagging")
+	_, err = dst.PutObjectTagging(ctx, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(dstBucket),
+		Key:     aws.String(key),
+		Tagging: &types.Tagging{TagSet: tagsOut.TagSet},
+	})
+	if err != nil {
+		return fmt.Errorf("put object tagging error: %w", err)
+	}
+	return nil
+}
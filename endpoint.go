@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// resolvedEndpoint returns the S3-compatible endpoint configured for the
+// given side ("SRC" or "DST") via <prefix>_ENDPOINT, or "" to use AWS's
+// default endpoint resolution. When the value has no scheme, it's derived
+// from <prefix>_DISABLE_SSL so MinIO/Ceph/LocalStack hosts can be given as
+// a bare host:port.
+func resolvedEndpoint(prefix string) string {
+	endpoint := os.Getenv(prefix + "_ENDPOINT")
+	if endpoint == "" {
+		return ""
+	}
+	if strings.Contains(endpoint, "://") {
+		return endpoint
+	}
+	scheme := "https"
+	if os.Getenv(prefix+"_DISABLE_SSL") == "true" {
+		scheme = "http"
+	}
+	return scheme + "://" + endpoint
+}
+
+// endpointLoadOption returns a config.LoadOptions func pointing the client
+// at a custom endpoint, or nil when <prefix>_ENDPOINT isn't set.
+func endpointLoadOption(prefix string) func(*config.LoadOptions) error {
+	endpoint := resolvedEndpoint(prefix)
+	if endpoint == "" {
+		return nil
+	}
+	resolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+		return aws.Endpoint{URL: endpoint, SigningRegion: region, HostnameImmutable: true}, nil
+	})
+	return config.WithEndpointResolverWithOptions(resolver)
+}
+
+// usePathStyle reports whether <prefix>_USE_PATH_STYLE requests path-style
+// addressing, needed for S3-compatible backends and bucket names with dots.
+func usePathStyle(prefix string) bool {
+	return os.Getenv(prefix+"_USE_PATH_STYLE") == "true"
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// multipartThreshold is the point above which S3 rejects a single
+// CopyObject/PutObject request (5 GB), forcing a multipart copy or upload.
+const multipartThreshold = 5 * 1024 * 1024 * 1024
+
+const (
+	defaultPartSizeMB          = 5
+	defaultUploadConcurrency   = 5
+	defaultDownloadConcurrency = 5
+)
+
+// s3MaxParts is S3's hard limit on the number of parts in a single
+// multipart upload.
+const s3MaxParts = 10000
+
+// partSizeBytes returns the configured multipart part size, defaulting to
+// 5 MiB (the S3 minimum) the way Arvados' S3 volume driver sizes its
+// downloader parts.
+func partSizeBytes() int64 {
+	return int64(getEnvInt("PART_SIZE_MB", defaultPartSizeMB)) * 1024 * 1024
+}
+
+// partSizeForObject returns the part size to use for an object of size
+// bytes: the configured PART_SIZE_MB, bumped up as needed so the object
+// never needs more than s3MaxParts parts. Without this, any object past
+// ~48.8 GB at the 5 MiB default would need PartNumber > 10000, which S3
+// rejects.
+func partSizeForObject(size int64) int64 {
+	configured := partSizeBytes()
+	minForSize := (size + s3MaxParts - 1) / s3MaxParts
+	if minForSize > configured {
+		return minForSize
+	}
+	return configured
+}
+
+func uploadConcurrency() int {
+	return getEnvInt("UPLOAD_CONCURRENCY", defaultUploadConcurrency)
+}
+
+func downloadConcurrency() int {
+	return getEnvInt("DOWNLOAD_CONCURRENCY", defaultDownloadConcurrency)
+}
+
+// multipartCopyObject performs a server-side copy of an object larger than
+// the 5 GB CopyObject limit by splitting it into parts with
+// CreateMultipartUpload/UploadPartCopy/CompleteMultipartUpload.
+func multipartCopyObject(ctx context.Context, src, dst *s3.Client, srcBucket, dstBucket, key string, headObj *s3.HeadObjectOutput, stats *TransferStats, mu *sync.Mutex) error {
+	size := *headObj.ContentLength
+
+	recordS3Call("CreateMultipartUpload")
+	create, err := dst.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:                    aws.String(dstBucket),
+		Key:                       aws.String(key),
+		ContentType:               headObj.ContentType,
+		Metadata:                  headObj.Metadata,
+		StorageClass:              destStorageClass(),
+		ServerSideEncryption:      destSSE(),
+		SSEKMSKeyId:               destSSEKMSKeyID(),
+		ACL:                       destACL(),
+		ObjectLockMode:            headObj.ObjectLockMode,
+		ObjectLockRetainUntilDate: headObj.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: headObj.ObjectLockLegalHoldStatus,
+	})
+	if err != nil {
+		return fmt.Errorf("create multipart upload error: %w", err)
+	}
+
+	partSize := partSizeForObject(size)
+	numParts := int((size + partSize - 1) / partSize)
+
+	var (
+		partsMu  sync.Mutex
+		parts    = make([]types.CompletedPart, 0, numParts)
+		errMu    sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	sem := make(chan struct{}, uploadConcurrency())
+
+	for i := 0; i < numParts; i++ {
+		partNum := int32(i + 1)
+		start := int64(i) * partSize
+		end := start + partSize - 1
+		if end > size-1 {
+			end = size - 1
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(partNum int32, start, end int64) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			recordS3Call("Copy")
+			out, err := src.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+				Bucket:          aws.String(dstBucket),
+				Key:             aws.String(key),
+				CopySource:      aws.String(fmt.Sprintf("%s/%s", srcBucket, key)),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+				PartNumber:      aws.Int32(partNum),
+				UploadId:        create.UploadId,
+			})
+			if err != nil {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("upload part copy %d error: %w", partNum, err)
+				}
+				errMu.Unlock()
+				return
+			}
+
+			partsMu.Lock()
+			parts = append(parts, types.CompletedPart{
+				ETag:       out.CopyPartResult.ETag,
+				PartNumber: aws.Int32(partNum),
+			})
+			partsMu.Unlock()
+		}(partNum, start, end)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		recordS3Call("AbortMultipartUpload")
+		_, _ = dst.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(dstBucket),
+			Key:      aws.String(key),
+			UploadId: create.UploadId,
+		})
+		return firstErr
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
+
+	recordS3Call("CompleteMultipartUpload")
+	_, err = dst.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(dstBucket),
+		Key:             aws.String(key),
+		UploadId:        create.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return fmt.Errorf("complete multipart upload error: %w", err)
+	}
+
+	mu.Lock()
+	stats.Method["multipart"]++
+	mu.Unlock()
+	log.WithFields(log.Fields{"key": key, "size": size}).Info("multipart copy completed")
+	return nil
+}
+
+// multipartStreamUpload is used when the source and destination accounts
+// don't support server-side UploadPartCopy between them (e.g. no bucket
+// policy granting GetObject to the destination role). It range-GETs the
+// part from the source and UploadParts it to the destination, bounding
+// download and upload fan-out independently via DOWNLOAD_CONCURRENCY and
+// UPLOAD_CONCURRENCY so neither side saturates the other, all without ever
+// touching local disk.
+func multipartStreamUpload(ctx context.Context, src, dst *s3.Client, srcBucket, dstBucket, key string, headObj *s3.HeadObjectOutput, stats *TransferStats, mu *sync.Mutex) (string, string, error) {
+	size := *headObj.ContentLength
+
+	recordS3Call("CreateMultipartUpload")
+	create, err := dst.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:                    aws.String(dstBucket),
+		Key:                       aws.String(key),
+		ContentType:               headObj.ContentType,
+		Metadata:                  headObj.Metadata,
+		StorageClass:              destStorageClass(),
+		ServerSideEncryption:      destSSE(),
+		SSEKMSKeyId:               destSSEKMSKeyID(),
+		ACL:                       destACL(),
+		ObjectLockMode:            headObj.ObjectLockMode,
+		ObjectLockRetainUntilDate: headObj.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: headObj.ObjectLockLegalHoldStatus,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("create multipart upload error: %w", err)
+	}
+
+	partSize := partSizeForObject(size)
+	numParts := int((size + partSize - 1) / partSize)
+
+	var (
+		partsMu  sync.Mutex
+		parts    = make([]types.CompletedPart, 0, numParts)
+		errMu    sync.Mutex
+		firstErr error
+		wg       sync.WaitGroup
+	)
+	downloadSem := make(chan struct{}, downloadConcurrency())
+	uploadSem := make(chan struct{}, uploadConcurrency())
+
+	for i := 0; i < numParts; i++ {
+		partNum := int32(i + 1)
+		start := int64(i) * partSize
+		end := start + partSize - 1
+		if end > size-1 {
+			end = size - 1
+		}
+
+		wg.Add(1)
+		go func(partNum int32, start, end int64) {
+			defer wg.Done()
+
+			// The download slot is held until the part's body has been
+			// consumed by UploadPart, so open source reads stay bounded.
+			downloadSem <- struct{}{}
+			defer func() { <-downloadSem }()
+			recordS3Call("Get")
+			obj, err := src.GetObject(ctx, &s3.GetObjectInput{
+				Bucket: aws.String(srcBucket),
+				Key:    aws.String(key),
+				Range:  aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+			})
+			if err != nil {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("get part %d error: %w", partNum, err)
+				}
+				errMu.Unlock()
+				return
+			}
+			defer obj.Body.Close()
+
+			uploadSem <- struct{}{}
+			recordS3Call("Put")
+			out, err := dst.UploadPart(ctx, &s3.UploadPartInput{
+				Bucket:     aws.String(dstBucket),
+				Key:        aws.String(key),
+				UploadId:   create.UploadId,
+				PartNumber: aws.Int32(partNum),
+				Body:       obj.Body,
+			})
+			<-uploadSem
+			if err != nil {
+				errMu.Lock()
+				if firstErr == nil {
+					firstErr = fmt.Errorf("upload part %d error: %w", partNum, err)
+				}
+				errMu.Unlock()
+				return
+			}
+
+			partsMu.Lock()
+			parts = append(parts, types.CompletedPart{ETag: out.ETag, PartNumber: aws.Int32(partNum)})
+			partsMu.Unlock()
+		}(partNum, start, end)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		recordS3Call("AbortMultipartUpload")
+		_, _ = dst.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(dstBucket),
+			Key:      aws.String(key),
+			UploadId: create.UploadId,
+		})
+		return "", "", firstErr
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return *parts[i].PartNumber < *parts[j].PartNumber })
+
+	recordS3Call("CompleteMultipartUpload")
+	_, err = dst.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(dstBucket),
+		Key:             aws.String(key),
+		UploadId:        create.UploadId,
+		MultipartUpload: &types.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("complete multipart streaming upload error: %w", err)
+	}
+
+	mu.Lock()
+	stats.Method["multipart"]++
+	mu.Unlock()
+
+	log.WithFields(log.Fields{"key": key}).Info("streamed successfully (multipart)")
+	return "multipart", "", nil
+}
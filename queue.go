@@ -0,0 +1,270 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ensureQueueSchema creates the tables backing the durable job queue if
+// they don't already exist, so a fresh database only needs document_logs
+// (checked by testDBConnection) provisioned ahead of time.
+func ensureQueueSchema(db *sql.DB) error {
+	stmts := []string{
+		`CREATE TABLE IF NOT EXISTS transfer_runs (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			src_bucket VARCHAR(255) NOT NULL,
+			dst_bucket VARCHAR(255) NOT NULL,
+			prefix VARCHAR(1024) NOT NULL,
+			state VARCHAR(32) NOT NULL DEFAULT 'running',
+			total_files BIGINT NOT NULL DEFAULT 0,
+			success_count BIGINT NOT NULL DEFAULT 0,
+			error_count BIGINT NOT NULL DEFAULT 0,
+			started_at DATETIME NOT NULL,
+			ended_at DATETIME NULL,
+			KEY idx_run_tuple (src_bucket, dst_bucket, prefix(255))
+		)`,
+		`CREATE TABLE IF NOT EXISTS transfer_jobs (
+			id BIGINT AUTO_INCREMENT PRIMARY KEY,
+			run_id BIGINT NOT NULL,
+			file_key VARCHAR(1024) NOT NULL,
+			status VARCHAR(32) NOT NULL DEFAULT 'pending',
+			attempt INT NOT NULL DEFAULT 0,
+			claimed_by VARCHAR(255) NULL,
+			created_at DATETIME NOT NULL,
+			updated_at DATETIME NOT NULL,
+			UNIQUE KEY uniq_run_key (run_id, file_key),
+			KEY idx_run_status (run_id, status)
+		)`,
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("queue schema setup error: %w", err)
+		}
+	}
+
+	if err := ensureDocumentLogsColumn(db, "run_id", `ALTER TABLE document_logs ADD COLUMN run_id BIGINT NULL, ADD KEY idx_run_id (run_id)`); err != nil {
+		return err
+	}
+	if err := ensureDocumentLogsColumn(db, "bytes_transferred", `ALTER TABLE document_logs ADD COLUMN bytes_transferred BIGINT NULL`); err != nil {
+		return err
+	}
+	if err := ensureDocumentLogsColumn(db, "checksum", `ALTER TABLE document_logs ADD COLUMN checksum VARCHAR(64) NULL`); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ensureDocumentLogsColumn adds column to the pre-existing document_logs
+// table via ddl if it isn't already there. document_logs predates
+// transfer_runs/transfer_jobs, so CREATE TABLE IF NOT EXISTS above never
+// migrates it; this mirrors the information_schema check testDBConnection
+// already does, rather than relying on ALTER ... ADD COLUMN IF NOT EXISTS
+// (only supported on MySQL 8.0.29+).
+func ensureDocumentLogsColumn(db *sql.DB, column, ddl string) error {
+	var exists int
+	err := db.QueryRow(`
+		SELECT COUNT(*)
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE()
+		AND table_name = 'document_logs'
+		AND column_name = ?
+	`, column).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("check document_logs.%s error: %w", column, err)
+	}
+	if exists > 0 {
+		return nil
+	}
+	if _, err := db.Exec(ddl); err != nil {
+		return fmt.Errorf("add document_logs.%s error: %w", column, err)
+	}
+	return nil
+}
+
+// getOrCreateRun resolves the transfer_runs row for the (srcBucket,
+// dstBucket, prefix) tuple. restart always starts a fresh run, aborting any
+// run still marked running. Otherwise an existing running row is always
+// resumed (crash recovery), and resume additionally reopens the most recent
+// completed/aborted row so its unfinished keys can be retried.
+func getOrCreateRun(db *sql.DB, srcBucket, dstBucket, prefix string, resume, restart bool) (runID int64, resumed bool, err error) {
+	var existingID int64
+	var state string
+	err = db.QueryRow(
+		`SELECT id, state FROM transfer_runs WHERE src_bucket = ? AND dst_bucket = ? AND prefix = ? ORDER BY id DESC LIMIT 1`,
+		srcBucket, dstBucket, prefix,
+	).Scan(&existingID, &state)
+
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return createRun(db, srcBucket, dstBucket, prefix)
+	case err != nil:
+		return 0, false, fmt.Errorf("lookup transfer run error: %w", err)
+	}
+
+	if restart {
+		if _, err := db.Exec(`UPDATE transfer_runs SET state = 'aborted', ended_at = ? WHERE id = ? AND state = 'running'`, time.Now(), existingID); err != nil {
+			return 0, false, fmt.Errorf("abort previous run error: %w", err)
+		}
+		return createRun(db, srcBucket, dstBucket, prefix)
+	}
+
+	if state == "running" || resume {
+		if _, err := db.Exec(`UPDATE transfer_runs SET state = 'running', ended_at = NULL WHERE id = ?`, existingID); err != nil {
+			return 0, false, fmt.Errorf("reopen transfer run error: %w", err)
+		}
+		return existingID, true, nil
+	}
+
+	return createRun(db, srcBucket, dstBucket, prefix)
+}
+
+func createRun(db *sql.DB, srcBucket, dstBucket, prefix string) (int64, bool, error) {
+	res, err := db.Exec(
+		`INSERT INTO transfer_runs (src_bucket, dst_bucket, prefix, state, started_at) VALUES (?, ?, ?, 'running', ?)`,
+		srcBucket, dstBucket, prefix, time.Now(),
+	)
+	if err != nil {
+		return 0, false, fmt.Errorf("create transfer run error: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, false, fmt.Errorf("create transfer run error: %w", err)
+	}
+	return id, false, nil
+}
+
+// seedJobs populates transfer_jobs for runID from the current bucket
+// listing. On a fresh run every key is enqueued pending. On a resumed run,
+// keys already recorded successful in document_logs are skipped and
+// everything else (never enqueued, still pending, or previously errored) is
+// (re-)marked pending, with its retry count reset, so it gets claimed again.
+// seedJobs runs on every process start (not just fresh runs), so a key a
+// different live instance currently has 'claimed' is left untouched — the
+// ON DUPLICATE KEY UPDATE only resets rows whose pre-existing status is
+// neither 'claimed' nor 'success', otherwise it would yank an in-flight
+// job back to 'pending' out from under the worker transferring it and let
+// another instance double-claim the same key.
+func seedJobs(db *sql.DB, runID int64, keys []string, resumed bool) error {
+	done := map[string]bool{}
+	if resumed {
+		var err error
+		done, err = successfulKeys(db, runID)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	for _, key := range keys {
+		if done[key] {
+			continue
+		}
+		_, err := db.Exec(
+			`INSERT INTO transfer_jobs (run_id, file_key, status, attempt, created_at, updated_at) VALUES (?, ?, 'pending', 0, ?, ?)
+			 ON DUPLICATE KEY UPDATE
+			   status = IF(status IN ('claimed', 'success'), status, 'pending'),
+			   claimed_by = IF(status IN ('claimed', 'success'), claimed_by, NULL),
+			   attempt = IF(status IN ('claimed', 'success'), attempt, 0),
+			   updated_at = IF(status IN ('claimed', 'success'), updated_at, VALUES(updated_at))`,
+			runID, key, now, now,
+		)
+		if err != nil {
+			return fmt.Errorf("seed job %s error: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func successfulKeys(db *sql.DB, runID int64) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT file_key FROM document_logs WHERE run_id = ? AND status = 'success'`, runID)
+	if err != nil {
+		return nil, fmt.Errorf("load completed keys error: %w", err)
+	}
+	defer rows.Close()
+
+	done := map[string]bool{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("scan completed key error: %w", err)
+		}
+		done[key] = true
+	}
+	return done, rows.Err()
+}
+
+// priorSuccessStats sums the success count and bytes transferred already
+// recorded in document_logs for runID, so a --resume run that skips
+// re-enqueuing those keys (see seedJobs) still reports accurate totals
+// instead of only counting what this process itself claims.
+func priorSuccessStats(db *sql.DB, runID int64) (count int64, bytes int64, err error) {
+	err = db.QueryRow(
+		`SELECT COUNT(*), COALESCE(SUM(bytes_transferred), 0) FROM document_logs WHERE run_id = ? AND status = 'success'`,
+		runID,
+	).Scan(&count, &bytes)
+	if err != nil {
+		return 0, 0, fmt.Errorf("load prior success stats error: %w", err)
+	}
+	return count, bytes, nil
+}
+
+// queuedJob is a transfer_jobs row claimed by this worker.
+type queuedJob struct {
+	ID      int64
+	Key     string
+	Attempt int
+}
+
+// claimJob atomically claims the oldest pending job for runID using
+// SELECT ... FOR UPDATE SKIP LOCKED, so multiple instances of the binary can
+// drain the same run's queue without claiming the same key twice. A nil job
+// with a nil error means the queue is currently empty.
+func claimJob(db *sql.DB, runID int64, claimant string) (*queuedJob, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("claim job tx error: %w", err)
+	}
+	defer tx.Rollback()
+
+	var job queuedJob
+	err = tx.QueryRow(
+		`SELECT id, file_key, attempt FROM transfer_jobs WHERE run_id = ? AND status = 'pending' ORDER BY id LIMIT 1 FOR UPDATE SKIP LOCKED`,
+		runID,
+	).Scan(&job.ID, &job.Key, &job.Attempt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("claim job error: %w", err)
+	}
+
+	if _, err := tx.Exec(`UPDATE transfer_jobs SET status = 'claimed', claimed_by = ?, updated_at = ? WHERE id = ?`, claimant, time.Now(), job.ID); err != nil {
+		return nil, fmt.Errorf("mark job claimed error: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("claim job commit error: %w", err)
+	}
+	return &job, nil
+}
+
+func completeJob(db *sql.DB, jobID int64, status string, attempt int) error {
+	_, err := db.Exec(`UPDATE transfer_jobs SET status = ?, attempt = ?, updated_at = ? WHERE id = ?`, status, attempt, time.Now(), jobID)
+	if err != nil {
+		return fmt.Errorf("complete job error: %w", err)
+	}
+	return nil
+}
+
+// finalizeRun records the terminal state and final counters on a run.
+func finalizeRun(db *sql.DB, runID int64, state string, stats *TransferStats) error {
+	_, err := db.Exec(
+		`UPDATE transfer_runs SET state = ?, total_files = ?, success_count = ?, error_count = ?, ended_at = ? WHERE id = ?`,
+		state, stats.TotalFiles, stats.SuccessCount, stats.ErrorCount, time.Now(), runID,
+	)
+	if err != nil {
+		return fmt.Errorf("finalize run error: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// getEnvInt reads an integer from the named environment variable, falling
+// back to def when the variable is unset or not a valid integer.
+func getEnvInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid value %q for %s, using default %d", v, name, def)
+		return def
+	}
+	return n
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// verifyTransfer compares the source and destination copies of key after a
+// transfer. Plain (single-part) ETags are MD5s of the object body and can be
+// compared directly; multipart ETags are "hex-partcount" and only equal
+// between source and destination when both sides used identical part
+// boundaries, so for those we fall back to a size check and log the part
+// counts for operator visibility instead of failing on a cosmetic mismatch.
+func verifyTransfer(ctx context.Context, src, dst *s3.Client, srcBucket, dstBucket, key string) error {
+	recordS3Call("Head")
+	srcHead, err := src.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(srcBucket), Key: aws.String(key)})
+	if err != nil {
+		return fmt.Errorf("verify head (source) error: %w", err)
+	}
+	recordS3Call("Head")
+	dstHead, err := dst.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(dstBucket), Key: aws.String(key)})
+	if err != nil {
+		return fmt.Errorf("verify head (destination) error: %w", err)
+	}
+
+	if aws.ToInt64(srcHead.ContentLength) != aws.ToInt64(dstHead.ContentLength) {
+		return fmt.Errorf("size mismatch for %s: src=%d dst=%d", key, aws.ToInt64(srcHead.ContentLength), aws.ToInt64(dstHead.ContentLength))
+	}
+
+	srcETag := strings.Trim(aws.ToString(srcHead.ETag), `"`)
+	dstETag := strings.Trim(aws.ToString(dstHead.ETag), `"`)
+
+	srcParts, srcMultipart := multipartPartCount(srcETag)
+	dstParts, dstMultipart := multipartPartCount(dstETag)
+
+	if !srcMultipart && !dstMultipart {
+		if srcETag != dstETag {
+			return fmt.Errorf("ETag mismatch for %s: src=%s dst=%s", key, srcETag, dstETag)
+		}
+		return nil
+	}
+
+	if srcMultipart && dstMultipart && srcParts != dstParts {
+		fmt.Printf("⚠️  Part count differs for %s (src=%d dst=%d parts); sizes match, accepting\n", key, srcParts, dstParts)
+	}
+	return nil
+}
+
+// multipartPartCount extracts the part count from a multipart ETag of the
+// form "<hex>-<partcount>". ok is false for plain, single-part ETags.
+func multipartPartCount(etag string) (count int, ok bool) {
+	idx := strings.LastIndex(etag, "-")
+	if idx < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(etag[idx+1:])
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+// tagChecksum records a SHA-256 digest on the destination object as
+// x-amz-meta-sha256 via an in-place metadata-replace copy, since the
+// streaming PutObject has already completed by the time the digest is known.
+// This copy re-specifies the same destination storage-class/SSE/ACL/
+// object-lock options as the original PutObject, since CopyObject resets
+// anything left unset back to the bucket's defaults.
+func tagChecksum(ctx context.Context, client *s3.Client, bucket, key string, headObj *s3.HeadObjectOutput, checksum string) error {
+	metadata := make(map[string]string, len(headObj.Metadata)+1)
+	for k, v := range headObj.Metadata {
+		metadata[k] = v
+	}
+	metadata["sha256"] = checksum
+
+	recordS3Call("Copy")
+	_, err := client.CopyObject(ctx, &s3.CopyObjectInput{
+		Bucket:                    aws.String(bucket),
+		Key:                       aws.String(key),
+		CopySource:                aws.String(fmt.Sprintf("%s/%s", bucket, key)),
+		MetadataDirective:         "REPLACE",
+		Metadata:                  metadata,
+		ContentType:               headObj.ContentType,
+		StorageClass:              destStorageClass(),
+		ServerSideEncryption:      destSSE(),
+		SSEKMSKeyId:               destSSEKMSKeyID(),
+		ACL:                       destACL(),
+		ObjectLockMode:            headObj.ObjectLockMode,
+		ObjectLockRetainUntilDate: headObj.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: headObj.ObjectLockLegalHoldStatus,
+	})
+	return err
+}